@@ -0,0 +1,288 @@
+// Copyright 2017-18 Daniel Swarbrick. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package drivedb
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ConvKind identifies the family of a parsed conv spec.
+type ConvKind string
+
+const (
+	ConvKindRaw          ConvKind = "raw"
+	ConvKindHex          ConvKind = "hex"
+	ConvKindAvg          ConvKind = "avg"
+	ConvKindTempMinMax   ConvKind = "tempminmax"
+	ConvKindMin2Hour     ConvKind = "min2hour"
+	ConvKindSec2Hour     ConvKind = "sec2hour"
+	ConvKindHalfMin2Hour ConvKind = "halfmin2hour"
+)
+
+// ConvSpec is a precompiled smartmontools-style attribute conversion rule, as
+// found in the "conv" field of a drivedb.h presets string (e.g. "raw48",
+// "raw24(raw8)", "raw48:54"). Use ParseConv to obtain one from its string
+// form, or ApplyConv to parse and apply in a single call.
+type ConvSpec struct {
+	Kind ConvKind
+
+	// Bits is the bit width of the raw/hex/avg value (8, 16, 24, 32 or 48).
+	Bits int
+
+	// ByteOrder, if non-empty, lists raw byte indices (0-5) from MSB to LSB
+	// used to build the value instead of the default little-endian layout,
+	// as in the "raw48:54" form. The resulting value is exactly len(ByteOrder)
+	// bytes wide, regardless of Bits — e.g. "raw48:54" yields a 16-bit value
+	// (raw[5]<<8 | raw[4]), not a 48-bit one padded out with the remaining
+	// bytes. This is what lets a short ByteOrder split a wide counter into a
+	// narrow error count in the high bytes and a separate low-byte tally.
+	ByteOrder []int
+
+	// Sub is the secondary spec parsed from a parenthesised suffix, e.g. the
+	// "raw8" in "raw24(raw8)". It is rendered alongside the primary value in
+	// parentheses. Nil if the conv string had no such suffix.
+	Sub *ConvSpec
+}
+
+// ParseConv parses a smartmontools-style conv string into a ConvSpec that can
+// be applied repeatedly without re-parsing.
+func ParseConv(conv string) (ConvSpec, error) {
+	conv = strings.TrimSpace(conv)
+	if conv == "" {
+		return ConvSpec{}, fmt.Errorf("drivedb: empty conv spec")
+	}
+
+	primary := conv
+	subStr := ""
+
+	if i := strings.IndexByte(conv, '('); i >= 0 {
+		if !strings.HasSuffix(conv, ")") {
+			return ConvSpec{}, fmt.Errorf("drivedb: malformed conv spec %q: unbalanced parentheses", conv)
+		}
+		primary = conv[:i]
+		subStr = conv[i+1 : len(conv)-1]
+	}
+
+	spec, err := parsePrimaryConv(primary)
+	if err != nil {
+		return ConvSpec{}, err
+	}
+
+	if subStr != "" {
+		sub, err := parsePrimaryConv(subStr)
+		if err != nil {
+			return ConvSpec{}, fmt.Errorf("drivedb: malformed conv spec %q: %v", conv, err)
+		}
+		spec.Sub = &sub
+	}
+
+	return spec, nil
+}
+
+// parsePrimaryConv parses a single conv token, i.e. a conv string with any
+// parenthesised suffix already stripped off.
+func parsePrimaryConv(s string) (ConvSpec, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+
+	switch s {
+	case "tempminmax":
+		return ConvSpec{Kind: ConvKindTempMinMax}, nil
+	case "min2hour":
+		return ConvSpec{Kind: ConvKindMin2Hour, Bits: 48}, nil
+	case "sec2hour":
+		return ConvSpec{Kind: ConvKindSec2Hour, Bits: 48}, nil
+	case "halfmin2hour":
+		return ConvSpec{Kind: ConvKindHalfMin2Hour, Bits: 48}, nil
+	}
+
+	kind := ConvKindRaw
+	rest := s
+
+	switch {
+	case strings.HasPrefix(s, "raw"):
+		rest = s[len("raw"):]
+	case strings.HasPrefix(s, "hex"):
+		kind = ConvKindHex
+		rest = s[len("hex"):]
+	case strings.HasPrefix(s, "avg"):
+		kind = ConvKindAvg
+		rest = s[len("avg"):]
+	default:
+		return ConvSpec{}, fmt.Errorf("drivedb: unknown conv spec %q", s)
+	}
+
+	bitsStr := rest
+	var order []int
+
+	if i := strings.IndexByte(rest, ':'); i >= 0 {
+		bitsStr = rest[:i]
+		digits := rest[i+1:]
+
+		if digits == "" {
+			return ConvSpec{}, fmt.Errorf("drivedb: empty byte order in conv spec %q", s)
+		}
+
+		order = make([]int, 0, len(digits))
+		for _, d := range digits {
+			if d < '0' || d > '5' {
+				return ConvSpec{}, fmt.Errorf("drivedb: invalid byte index %q in conv spec %q", string(d), s)
+			}
+			order = append(order, int(d-'0'))
+		}
+	}
+
+	bits, err := strconv.Atoi(bitsStr)
+	if err != nil {
+		return ConvSpec{}, fmt.Errorf("drivedb: invalid bit width in conv spec %q", s)
+	}
+
+	switch bits {
+	case 8, 16, 24, 32, 48:
+	default:
+		return ConvSpec{}, fmt.Errorf("drivedb: unsupported bit width %d in conv spec %q", bits, s)
+	}
+
+	return ConvSpec{Kind: kind, Bits: bits, ByteOrder: order}, nil
+}
+
+// rawUint assembles an unsigned integer from raw, using order if set,
+// otherwise the default little-endian layout of the low bits/8 bytes (raw[0]
+// is the least significant byte).
+//
+// When order is set, the resulting value is exactly len(order) bytes wide —
+// one byte per index, MSB first — not bits/8 bytes. A short order (e.g. the
+// two digits in "raw48:54") intentionally selects a narrower value than bits
+// would otherwise imply, so that it doesn't fold unrelated low bytes back in.
+func rawUint(raw [6]byte, bits int, order []int) uint64 {
+	var v uint64
+
+	if len(order) > 0 {
+		for _, b := range order {
+			v = v<<8 | uint64(raw[b])
+		}
+
+		return v
+	}
+
+	nbytes := bits / 8
+	for i := nbytes - 1; i >= 0; i-- {
+		v = v<<8 | uint64(raw[i])
+	}
+
+	return v
+}
+
+// consumedBytes returns how many of raw's low bytes spec's primary value
+// occupies, so that a parenthesised sub-spec can be read from what's left.
+func (s ConvSpec) consumedBytes() int {
+	if len(s.ByteOrder) > 0 {
+		return len(s.ByteOrder)
+	}
+	return s.Bits / 8
+}
+
+// shiftRaw returns raw with its low n bytes dropped and the rest slid down,
+// so that byte n of raw becomes byte 0 of the result. This lets a
+// parenthesised sub-spec (e.g. the "raw8" in "raw24(raw8)") read the bytes
+// the primary spec didn't consume, instead of re-reading the low bytes.
+func shiftRaw(raw [6]byte, n int) [6]byte {
+	var out [6]byte
+	for i := 0; i+n < 6; i++ {
+		out[i] = raw[i+n]
+	}
+	return out
+}
+
+// Apply converts raw according to the spec, returning a numeric value
+// suitable for e.g. threshold comparisons, and a human-readable display
+// string matching smartctl's conventions.
+func (s ConvSpec) Apply(raw [6]byte) (pretty int64, display string, err error) {
+	switch s.Kind {
+	case ConvKindTempMinMax:
+		// Byte 0 is the current temperature; bytes 2-5 encode min/max as a
+		// pair of 16-bit little-endian values.
+		cur := int64(raw[0])
+		min := int64(raw[2]) | int64(raw[3])<<8
+		max := int64(raw[4]) | int64(raw[5])<<8
+		return cur, fmt.Sprintf("%d (Min/Max %d/%d)", cur, min, max), nil
+
+	case ConvKindMin2Hour:
+		v := rawUint(raw, 48, nil) / 60
+		return int64(v), strconv.FormatUint(v, 10), nil
+
+	case ConvKindSec2Hour:
+		v := rawUint(raw, 48, nil) / 3600
+		return int64(v), strconv.FormatUint(v, 10), nil
+
+	case ConvKindHalfMin2Hour:
+		v := rawUint(raw, 48, nil) / 120
+		return int64(v), strconv.FormatUint(v, 10), nil
+
+	case ConvKindHex:
+		v := rawUint(raw, s.Bits, s.ByteOrder)
+		return int64(v), fmt.Sprintf("0x%0*x", s.Bits/4, v), nil
+
+	case ConvKindRaw, ConvKindAvg:
+		v := rawUint(raw, s.Bits, s.ByteOrder)
+		display := strconv.FormatUint(v, 10)
+
+		if s.Sub != nil {
+			// The sub-spec describes the bytes the primary didn't consume
+			// (e.g. the high word in raw16(raw16)), not a re-read of the
+			// same low bytes.
+			subPretty, subDisplay, err := s.Sub.Apply(shiftRaw(raw, s.consumedBytes()))
+			if err != nil {
+				return 0, "", err
+			}
+			if subPretty != 0 {
+				if s.Sub.Kind == ConvKindAvg {
+					display = fmt.Sprintf("%s (Average %s)", display, subDisplay)
+				} else {
+					display = fmt.Sprintf("%s (%s)", display, subDisplay)
+				}
+			}
+		}
+
+		return int64(v), display, nil
+
+	default:
+		return 0, "", fmt.Errorf("drivedb: unhandled conv kind %q", s.Kind)
+	}
+}
+
+// ApplyConv parses conv and applies it to raw in a single call. Callers that
+// convert many attributes against the same conv string repeatedly should
+// call ParseConv once and reuse the resulting ConvSpec instead.
+func ApplyConv(conv string, raw [6]byte) (pretty int64, display string, err error) {
+	spec, err := ParseConv(conv)
+	if err != nil {
+		return 0, "", err
+	}
+
+	return spec.Apply(raw)
+}
+
+// Convert looks up the preset for the given SMART attribute id and applies
+// its conv spec to raw.
+func (m DriveModel) Convert(id uint8, raw [6]byte) (pretty int64, display string, err error) {
+	p, ok := m.Presets[strconv.Itoa(int(id))]
+	if !ok {
+		return 0, "", fmt.Errorf("drivedb: no preset for attribute %d", id)
+	}
+
+	return ApplyConv(p.Conv, raw)
+}
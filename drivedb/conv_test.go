@@ -0,0 +1,84 @@
+// Copyright 2017-18 Daniel Swarbrick. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package drivedb
+
+import "testing"
+
+func TestApplyConv(t *testing.T) {
+	cases := []struct {
+		conv    string
+		raw     [6]byte
+		pretty  int64
+		display string
+	}{
+		{"raw48", [6]byte{0x01, 0x00, 0x00, 0x00, 0x00, 0x00}, 1, "1"},
+		{"raw8", [6]byte{0xff, 0xff, 0, 0, 0, 0}, 0xff, "255"},
+		{"raw16", [6]byte{0x34, 0x12, 0, 0, 0, 0}, 0x1234, "4660"},
+		{"hex48", [6]byte{0x01, 0x00, 0x00, 0x00, 0x00, 0x00}, 1, "0x000000000001"},
+		// The parenthesised sub-spec reads the bytes the primary didn't
+		// consume (the "upper" bytes), not a re-read of the primary's own
+		// low bytes.
+		{"raw24(raw8)", [6]byte{0x05, 0x00, 0x01, 0x07, 0, 0}, 0x010005, "65541 (7)"},
+		{"raw16(raw16)", [6]byte{0x02, 0x00, 0x07, 0x00, 0, 0}, 2, "2 (7)"},
+		// When the sub-spec's bytes are all zero, the parenthetical is
+		// omitted entirely rather than printed as "(0)".
+		{"raw24(raw8)", [6]byte{0x05, 0x00, 0x01, 0, 0, 0}, 0x010005, "65541"},
+		// avg16 labels its value "Average N", per smartctl.
+		{"raw16(avg16)", [6]byte{0x0a, 0x00, 0x05, 0x00, 0, 0}, 10, "10 (Average 5)"},
+		{"tempminmax", [6]byte{25, 0, 10, 0, 40, 0}, 25, "25 (Min/Max 10/40)"},
+
+		// The byte-order form selects exactly the bytes it names, MSB
+		// first, and must not fold the remaining low bytes back in: a
+		// 16-bit value built from raw[5] and raw[4] only, ignoring the
+		// low-byte event counter in raw[0..3].
+		{"raw48:54", [6]byte{0xff, 0xff, 0xff, 0xff, 0x02, 0x01}, 0x0102, "258"},
+	}
+
+	for _, c := range cases {
+		pretty, display, err := ApplyConv(c.conv, c.raw)
+		if err != nil {
+			t.Errorf("ApplyConv(%q, %v): unexpected error: %v", c.conv, c.raw, err)
+			continue
+		}
+		if pretty != c.pretty || display != c.display {
+			t.Errorf("ApplyConv(%q, %v) = (%d, %q), want (%d, %q)",
+				c.conv, c.raw, pretty, display, c.pretty, c.display)
+		}
+	}
+}
+
+func TestApplyConvErrors(t *testing.T) {
+	for _, conv := range []string{"", "bogus", "raw17", "raw48(", "raw48:6"} {
+		if _, _, err := ApplyConv(conv, [6]byte{}); err == nil {
+			t.Errorf("ApplyConv(%q, ...): expected error, got nil", conv)
+		}
+	}
+}
+
+func TestDriveModelConvert(t *testing.T) {
+	m := DB.Drives[0] // DEFAULT
+
+	pretty, display, err := m.Convert(9, [6]byte{0x10, 0x00, 0x05, 0x0f, 0, 0})
+	if err != nil {
+		t.Fatalf("Convert(9, ...): unexpected error: %v", err)
+	}
+	if pretty != 0x050010 || display != "327696 (15)" {
+		t.Errorf("Convert(9, ...) = (%d, %q), want (327696, \"327696 (15)\")", pretty, display)
+	}
+
+	if _, _, err := m.Convert(255, [6]byte{}); err == nil {
+		t.Errorf("Convert(255, ...): expected error for unknown attribute, got nil")
+	}
+}
@@ -26,19 +26,42 @@ import (
 type AttrConv struct {
 	Conv string `yaml:"conv"`
 	Name string `yaml:"name"`
+
+	// Crit marks this attribute as critical for Evaluate, regardless of
+	// whether its id is in CriticalAttrIDs.
+	Crit bool `yaml:"crit"`
+
+	// FailIfNonZero marks this attribute as warranting a warning whenever
+	// its converted raw value is non-zero, same as a critical attribute.
+	FailIfNonZero bool `yaml:"fail_if_nonzero"`
 }
 
 type DriveModel struct {
-	Family         string              `yaml:"family"`
-	ModelRegex     string              `yaml:"model_regex"`
-	FirmwareRegex  string              `yaml:"firmware_regex"`
-	WarningMsg     string              `yaml:"warning"`
-	Presets        map[string]AttrConv `yaml:"presets"`
+	Family        string `yaml:"family"`
+	ModelRegex    string `yaml:"model_regex"`
+	FirmwareRegex string `yaml:"firmware_regex"`
+	WarningMsg    string `yaml:"warning"`
+
+	// Protocol is the protocol this entry describes: "ata" (the default, for
+	// backwards compatibility with entries that don't set it), "nvme" or
+	// "scsi". LookupDriveBy only matches an entry against lookups for its
+	// protocol. Convert and Evaluate read Presets whatever the protocol.
+	Protocol string `yaml:"protocol"`
+
+	Presets     map[string]AttrConv `yaml:"presets"`
+	NvmePresets map[string]AttrConv `yaml:"nvme_presets"`
+	ScsiPresets map[string]AttrConv `yaml:"scsi_presets"`
+
 	CompiledRegexp *regexp.Regexp
 }
 
 type DriveDb struct {
 	Drives []DriveModel `yaml:"drives"`
+
+	// UserOverrides are applied on top of the family-matched presets by
+	// LookupDriveFull. They are loaded separately via OpenUserOverrides, not
+	// as part of the main drivedb YAML.
+	UserOverrides []UserOverride `yaml:"-"`
 }
 
 var DB DriveDb
@@ -114,6 +137,24 @@ func init() {
 					"250": {Conv: "raw48", Name: "Read_Error_Retry_Rate"},
 					"254": {Conv: "raw48", Name: "Free_Fall_Sensor"},
 				},
+				NvmePresets: map[string]AttrConv{
+					"critical_warning":   {Conv: "hex8", Name: "Critical_Warning"},
+					"available_spare":    {Conv: "raw8", Name: "Available_Spare"},
+					"percentage_used":    {Conv: "raw8", Name: "Percentage_Used"},
+					"media_errors":       {Conv: "raw48", Name: "Media_Errors"},
+					"power_cycles":       {Conv: "raw48", Name: "Power_Cycles"},
+					"power_on_hours":     {Conv: "raw48", Name: "Power_On_Hours"},
+					"data_units_written": {Conv: "raw48", Name: "Data_Units_Written"},
+					"data_units_read":    {Conv: "raw48", Name: "Data_Units_Read"},
+				},
+				ScsiPresets: map[string]AttrConv{
+					"grown_defect_list":         {Conv: "raw48", Name: "Grown_Defect_List"},
+					"read_uncorrected_errors":   {Conv: "raw48", Name: "Read_Uncorrected_Errors"},
+					"write_uncorrected_errors":  {Conv: "raw48", Name: "Write_Uncorrected_Errors"},
+					"verify_uncorrected_errors": {Conv: "raw48", Name: "Verify_Uncorrected_Errors"},
+					"non_medium_errors":         {Conv: "raw48", Name: "Non_Medium_Errors"},
+					"temperature":               {Conv: "raw8", Name: "Temperature_Celsius"},
+				},
 			},
 		},
 	}
@@ -121,6 +162,14 @@ func init() {
 
 // LookupDrive returns the most appropriate DriveModel for a given ATA IDENTIFY value.
 func (db *DriveDb) LookupDrive(ident []byte) DriveModel {
+	return db.LookupDriveBy("ata", ident)
+}
+
+// LookupDriveBy returns the most appropriate DriveModel for the given
+// protocol ("ata", "nvme" or "scsi") and identification buffer (an ATA
+// IDENTIFY buffer, an NVMe Identify Controller buffer, or a SCSI INQUIRY VPD
+// page, respectively).
+func (db *DriveDb) LookupDriveBy(protocol string, ident []byte) DriveModel {
 	var model DriveModel
 
 	for _, d := range db.Drives {
@@ -131,6 +180,22 @@ func (db *DriveDb) LookupDrive(ident []byte) DriveModel {
 
 		if d.Family == "DEFAULT" {
 			model = d
+			// Copy the preset maps so that merging family or user-override
+			// presets into model below doesn't mutate the DEFAULT entry
+			// shared by every other lookup.
+			model.Presets = copyPresets(d.Presets)
+			model.NvmePresets = copyPresets(d.NvmePresets)
+			model.ScsiPresets = copyPresets(d.ScsiPresets)
+			continue
+		}
+
+		// Entries without an explicit protocol are assumed to be ATA, for
+		// compatibility with existing drivedb entries.
+		entryProtocol := d.Protocol
+		if entryProtocol == "" {
+			entryProtocol = "ata"
+		}
+		if entryProtocol != protocol {
 			continue
 		}
 
@@ -141,23 +206,40 @@ func (db *DriveDb) LookupDrive(ident []byte) DriveModel {
 			model.WarningMsg = d.WarningMsg
 			model.CompiledRegexp = d.CompiledRegexp
 
-			for id, p := range d.Presets {
-				if _, exists := model.Presets[id]; exists {
-					// Some drives override the conv but don't specify a name, so copy it from default
-					if p.Name == "" {
-						p.Name = model.Presets[id].Name
-					}
-				}
-				model.Presets[id] = AttrConv{Name: p.Name, Conv: p.Conv}
-			}
+			mergePresets(model.Presets, d.Presets)
+			mergePresets(model.NvmePresets, d.NvmePresets)
+			mergePresets(model.ScsiPresets, d.ScsiPresets)
 
 			break
 		}
 	}
 
+	model.Protocol = protocol
+
 	return model
 }
 
+// mergePresets copies each entry of src into dst, carrying the existing name
+// forward when an override specifies a conv but no name.
+func mergePresets(dst, src map[string]AttrConv) {
+	for id, p := range src {
+		if cur, exists := dst[id]; exists && p.Name == "" {
+			p.Name = cur.Name
+		}
+		dst[id] = p
+	}
+}
+
+// copyPresets returns a shallow copy of presets, so callers can merge into it
+// without mutating the original map.
+func copyPresets(presets map[string]AttrConv) map[string]AttrConv {
+	cp := make(map[string]AttrConv, len(presets))
+	for id, p := range presets {
+		cp[id] = p
+	}
+	return cp
+}
+
 // OpenDriveDb opens a YAML-formatted drive database, unmarshalls it, and returns a DriveDb.
 func OpenDriveDb(dbfile string) (DriveDb, error) {
 	var db DriveDb
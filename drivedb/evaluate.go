@@ -0,0 +1,136 @@
+// Copyright 2017-18 Daniel Swarbrick. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package drivedb
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// AttrStatus is the health verdict for a single SMART attribute. Higher
+// values are worse, so the worst of a set of statuses can be found with a
+// simple comparison.
+type AttrStatus int
+
+const (
+	StatusOK AttrStatus = iota
+	StatusWarning
+	StatusFailed
+)
+
+func (s AttrStatus) String() string {
+	switch s {
+	case StatusOK:
+		return "OK"
+	case StatusWarning:
+		return "WARNING"
+	case StatusFailed:
+		return "FAILED"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// CriticalAttrIDs is the default set of ATA SMART attribute ids for which
+// Evaluate treats any non-zero converted raw value as a warning, even
+// without an explicit Crit or FailIfNonZero preset. Callers may add to or
+// replace this set to tune the policy.
+var CriticalAttrIDs = map[uint8]bool{
+	5:   true, // Reallocated_Sector_Ct
+	10:  true, // Spin_Retry_Count
+	184: true, // End-to-End_Error
+	187: true, // Reported_Uncorrect
+	188: true, // Command_Timeout
+	196: true, // Reallocated_Event_Count
+	197: true, // Current_Pending_Sector
+	198: true, // Offline_Uncorrectable
+	201: true, // Soft_Read_Error_Rate
+}
+
+// Attribute holds the values needed to evaluate the health of a single SMART
+// attribute, as read from a drive's SMART attribute table.
+type Attribute struct {
+	ID         uint8
+	Current    uint8
+	Worst      uint8
+	Threshold  uint8
+	RawBytes   [6]byte
+	WhenFailed string
+}
+
+// Evaluate assesses the health of a single attribute against m's presets.
+//
+// If whenFailed is "FAILING_NOW" the attribute has already tripped its
+// manufacturer threshold and the result is always StatusFailed. If it is
+// "IN_THE_PAST" the result is StatusWarning. Otherwise, the attribute is
+// checked against m's critical attribute set (CriticalAttrIDs, plus any
+// preset marked Crit or FailIfNonZero): for those, a non-zero converted raw
+// value is a warning. Everything else is StatusOK.
+func (m DriveModel) Evaluate(id uint8, current, worst, threshold uint8, rawBytes [6]byte, whenFailed string) (AttrStatus, string) {
+	switch whenFailed {
+	case "FAILING_NOW":
+		return StatusFailed, "Attribute is failing manufacturer SMART threshold"
+	case "IN_THE_PAST":
+		return StatusWarning, "Attribute has failed manufacturer SMART threshold in the past"
+	}
+
+	preset, ok := m.Presets[strconv.Itoa(int(id))]
+
+	name := preset.Name
+	if name == "" {
+		name = fmt.Sprintf("attribute %d", id)
+	}
+
+	if !CriticalAttrIDs[id] && !preset.Crit && !preset.FailIfNonZero {
+		return StatusOK, ""
+	}
+
+	var pretty int64
+	if ok && preset.Conv != "" {
+		var err error
+		pretty, _, err = ApplyConv(preset.Conv, rawBytes)
+		if err != nil {
+			pretty = int64(rawUint(rawBytes, 48, nil))
+		}
+	} else {
+		pretty = int64(rawUint(rawBytes, 48, nil))
+	}
+
+	if pretty != 0 {
+		return StatusWarning, fmt.Sprintf("%s is non-zero (%d)", name, pretty)
+	}
+
+	return StatusOK, ""
+}
+
+// EvaluateAll evaluates every attribute in attrs against m's presets and
+// returns the worst status seen, along with the reasons for every attribute
+// that did not come back StatusOK.
+func (m DriveModel) EvaluateAll(attrs []Attribute) (AttrStatus, []string) {
+	worst := StatusOK
+	reasons := make([]string, 0, len(attrs))
+
+	for _, a := range attrs {
+		status, reason := m.Evaluate(a.ID, a.Current, a.Worst, a.Threshold, a.RawBytes, a.WhenFailed)
+		if reason != "" {
+			reasons = append(reasons, reason)
+		}
+		if status > worst {
+			worst = status
+		}
+	}
+
+	return worst, reasons
+}
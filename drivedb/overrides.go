@@ -0,0 +1,120 @@
+// Copyright 2017-18 Daniel Swarbrick. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package drivedb
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+
+	"gopkg.in/yaml.v2"
+)
+
+// UserOverride re-maps presets for drives matching a model/serial/firmware
+// pattern, on top of whatever family entry LookupDriveFull already matched.
+// This lets a user correct a wrong conv for their specific drive (e.g. a
+// Seagate Ironwolf needing raw48:54 on attributes 1 and 7) without forking
+// the whole family entry. An empty regex matches anything.
+type UserOverride struct {
+	ModelRegex    string              `yaml:"model_regex"`
+	SerialRegex   string              `yaml:"serial_regex"`
+	FirmwareRegex string              `yaml:"firmware_regex"`
+	Presets       map[string]AttrConv `yaml:"presets"`
+
+	compiledModel    *regexp.Regexp
+	compiledSerial   *regexp.Regexp
+	compiledFirmware *regexp.Regexp
+}
+
+func (o UserOverride) matches(model, firmware, serial string) bool {
+	if o.compiledModel != nil && !o.compiledModel.MatchString(model) {
+		return false
+	}
+	if o.compiledFirmware != nil && !o.compiledFirmware.MatchString(firmware) {
+		return false
+	}
+	if o.compiledSerial != nil && !o.compiledSerial.MatchString(serial) {
+		return false
+	}
+
+	return true
+}
+
+// userOverridesFile mirrors the root of a user overrides YAML document.
+type userOverridesFile struct {
+	Overrides []UserOverride `yaml:"overrides"`
+}
+
+// OpenUserOverrides opens a YAML file of UserOverride entries, compiles
+// their regexes, and returns them for assignment to DriveDb.UserOverrides.
+// Keeping overrides in a separate file from the main drivedb means upstream
+// drivedb updates don't clobber a user's local tweaks.
+func OpenUserOverrides(path string) ([]UserOverride, error) {
+	var uof userOverridesFile
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil
+	}
+
+	defer f.Close()
+	dec := yaml.NewDecoder(f)
+
+	if err := dec.Decode(&uof); err != nil {
+		return nil, err
+	}
+
+	for i, o := range uof.Overrides {
+		if o.ModelRegex != "" {
+			uof.Overrides[i].compiledModel, err = regexp.Compile(o.ModelRegex)
+			if err != nil {
+				return nil, fmt.Errorf("drivedb: invalid model_regex %q: %v", o.ModelRegex, err)
+			}
+		}
+		if o.SerialRegex != "" {
+			uof.Overrides[i].compiledSerial, err = regexp.Compile(o.SerialRegex)
+			if err != nil {
+				return nil, fmt.Errorf("drivedb: invalid serial_regex %q: %v", o.SerialRegex, err)
+			}
+		}
+		if o.FirmwareRegex != "" {
+			uof.Overrides[i].compiledFirmware, err = regexp.Compile(o.FirmwareRegex)
+			if err != nil {
+				return nil, fmt.Errorf("drivedb: invalid firmware_regex %q: %v", o.FirmwareRegex, err)
+			}
+		}
+	}
+
+	return uof.Overrides, nil
+}
+
+// LookupDriveFull returns the DriveModel for an ATA drive identified by its
+// already-parsed model, firmware and serial strings (as read from the
+// IDENTIFY buffer), with the first matching UserOverride applied on top of
+// the family-matched presets.
+func (db *DriveDb) LookupDriveFull(model, firmware, serial string) DriveModel {
+	m := db.LookupDriveBy("ata", []byte(model+" "+firmware))
+
+	for _, o := range db.UserOverrides {
+		if !o.matches(model, firmware, serial) {
+			continue
+		}
+
+		mergePresets(m.Presets, o.Presets)
+		break
+	}
+
+	return m
+}
@@ -0,0 +1,290 @@
+// Copyright 2017-18 Daniel Swarbrick. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package drivedb
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// OpenSmartmontoolsDb opens a smartmontools drivedb.h file (the canonical C
+// source of drive presets shipped with smartmontools), parses its
+// "{ family, model_regex, firmware_regex, warning, presets }" initializer
+// list, and returns the equivalent DriveDb. This lets callers point the
+// module at an upstream drivedb.h instead of maintaining a parallel YAML
+// fork.
+func OpenSmartmontoolsDb(path string) (DriveDb, error) {
+	var db DriveDb
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return db, err
+	}
+
+	src := stripCComments(string(raw))
+
+	records, err := extractCRecords(src)
+	if err != nil {
+		return db, err
+	}
+
+	db.Drives = make([]DriveModel, 0, len(records))
+
+	for _, rec := range records {
+		fields, err := splitCStringFields(rec)
+		if err != nil {
+			return db, fmt.Errorf("drivedb: %v in record %q", err, rec)
+		}
+		if len(fields) < 4 {
+			return db, fmt.Errorf("drivedb: record has %d fields, want at least 4: %q", len(fields), rec)
+		}
+
+		model := DriveModel{
+			Family:        fields[0],
+			ModelRegex:    fields[1],
+			FirmwareRegex: fields[2],
+			WarningMsg:    fields[3],
+			Presets:       map[string]AttrConv{},
+		}
+
+		if len(fields) >= 5 {
+			parsePresetsDirectives(fields[4], &model)
+		}
+
+		model.CompiledRegexp, _ = regexp.Compile(model.ModelRegex)
+
+		db.Drives = append(db.Drives, model)
+	}
+
+	return db, nil
+}
+
+// stripCComments removes // and /* */ comments from a C source string,
+// leaving string literal contents untouched.
+func stripCComments(s string) string {
+	var out strings.Builder
+
+	inString := false
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+
+		if inString {
+			out.WriteByte(c)
+			if c == '\\' && i+1 < len(s) {
+				i++
+				out.WriteByte(s[i])
+				continue
+			}
+			if c == '"' {
+				inString = false
+			}
+			continue
+		}
+
+		switch {
+		case c == '"':
+			inString = true
+			out.WriteByte(c)
+		case c == '/' && i+1 < len(s) && s[i+1] == '/':
+			for i < len(s) && s[i] != '\n' {
+				i++
+			}
+			out.WriteByte('\n')
+		case c == '/' && i+1 < len(s) && s[i+1] == '*':
+			i += 2
+			for i+1 < len(s) && !(s[i] == '*' && s[i+1] == '/') {
+				i++
+			}
+			i++
+			out.WriteByte(' ')
+		default:
+			out.WriteByte(c)
+		}
+	}
+
+	return out.String()
+}
+
+// extractCRecords finds every brace-delimited "{ ... }" record that appears
+// one level below the outermost (array initializer) brace, and returns each
+// record's inner text.
+func extractCRecords(s string) ([]string, error) {
+	var records []string
+
+	depth := 0
+	recStart := -1
+	inString := false
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+
+		if inString {
+			if c == '\\' {
+				i++
+			} else if c == '"' {
+				inString = false
+			}
+			continue
+		}
+
+		switch c {
+		case '"':
+			inString = true
+		case '{':
+			depth++
+			if depth == 2 {
+				recStart = i + 1
+			}
+		case '}':
+			if depth == 2 {
+				records = append(records, s[recStart:i])
+			}
+			depth--
+			if depth < 0 {
+				return nil, fmt.Errorf("drivedb: unbalanced braces")
+			}
+		}
+	}
+
+	return records, nil
+}
+
+// splitCStringFields parses the inner text of a record (a comma-separated
+// list of string literals, where adjacent string literals with no comma
+// between them are concatenated per C semantics) and returns the decoded
+// field values.
+func splitCStringFields(s string) ([]string, error) {
+	var fields []string
+
+	var cur strings.Builder
+	haveToken := false
+
+	i := 0
+	for i < len(s) {
+		c := s[i]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '"':
+			lit, n, err := decodeCString(s[i:])
+			if err != nil {
+				return nil, err
+			}
+			cur.WriteString(lit)
+			haveToken = true
+			i += n
+		case c == ',':
+			fields = append(fields, cur.String())
+			cur.Reset()
+			haveToken = false
+			i++
+		default:
+			return nil, fmt.Errorf("unexpected character %q", c)
+		}
+	}
+
+	if haveToken || cur.Len() > 0 {
+		fields = append(fields, cur.String())
+	}
+
+	return fields, nil
+}
+
+// decodeCString decodes a double-quoted C string literal at the start of s,
+// returning the decoded value and the number of bytes consumed from s.
+func decodeCString(s string) (string, int, error) {
+	if len(s) == 0 || s[0] != '"' {
+		return "", 0, fmt.Errorf("expected string literal")
+	}
+
+	var out strings.Builder
+
+	i := 1
+	for i < len(s) {
+		c := s[i]
+
+		if c == '"' {
+			return out.String(), i + 1, nil
+		}
+
+		if c == '\\' && i+1 < len(s) {
+			switch s[i+1] {
+			case 'n':
+				out.WriteByte('\n')
+			case 't':
+				out.WriteByte('\t')
+			case '"':
+				out.WriteByte('"')
+			case '\\':
+				out.WriteByte('\\')
+			default:
+				out.WriteByte(s[i+1])
+			}
+			i += 2
+			continue
+		}
+
+		out.WriteByte(c)
+		i++
+	}
+
+	return "", 0, fmt.Errorf("unterminated string literal")
+}
+
+// parsePresetsDirectives parses a drivedb.h presets string (space-separated
+// "-v ID,CONV[,NAME]" and "-F FIRMWAREBUG" directives), filling in
+// model.Presets from the -v directives and appending each -F bug to
+// model.WarningMsg.
+func parsePresetsDirectives(presets string, model *DriveModel) {
+	tokens := strings.Fields(presets)
+
+	for i := 0; i < len(tokens); i++ {
+		switch tokens[i] {
+		case "-v":
+			if i+1 >= len(tokens) {
+				break
+			}
+			i++
+			parts := strings.SplitN(tokens[i], ",", 3)
+			if len(parts) < 2 {
+				break
+			}
+			if _, err := strconv.Atoi(parts[0]); err != nil {
+				break
+			}
+
+			conv := AttrConv{Conv: parts[1]}
+			if len(parts) == 3 {
+				conv.Name = parts[2]
+			}
+			model.Presets[parts[0]] = conv
+
+		case "-F":
+			if i+1 >= len(tokens) {
+				break
+			}
+			i++
+			if model.WarningMsg != "" {
+				model.WarningMsg += "; "
+			}
+			model.WarningMsg += "firmware bug: " + tokens[i]
+		}
+	}
+}